@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 抽象了限速器获取当前时间和定时器的方式。生产环境使用 NewRealClock，
+// 测试里用 NewFakeClock 手动推进时间，从而避免依赖 sleep 的 flaky 测试。
+type Clock interface {
+	Now() int64
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer 是 time.Timer 的最小子集，抽象出来是为了让 FakeClock 能够手动触发。
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type realClock struct{}
+
+// NewRealClock 返回基于标准库 time 包的 Clock，是所有构造函数的默认实现。
+func NewRealClock() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() int64 {
+	return time.Now().UnixNano()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (r realTimer) C() <-chan time.Time {
+	return r.t.C
+}
+
+func (r realTimer) Stop() bool {
+	return r.t.Stop()
+}
+
+// FakeClock 是一个可以手动推进的 Clock，供测试使用。
+type FakeClock struct {
+	mu     sync.Mutex
+	now    int64
+	timers []*fakeTimer
+}
+
+// NewFakeClock 返回一个以 t0（UnixNano）为起点的 FakeClock。
+func NewFakeClock(t0 int64) *FakeClock {
+	return &FakeClock{now: t0}
+}
+
+func (f *FakeClock) Now() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance 把时钟向前推进 d，并触发所有到期的定时器。
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	f.now += int64(d)
+	now := f.now
+	pending := f.timers
+	f.timers = nil
+	var fired []*fakeTimer
+	for _, t := range pending {
+		if t.deadline <= now {
+			fired = append(fired, t)
+		} else {
+			f.timers = append(f.timers, t)
+		}
+	}
+	f.mu.Unlock()
+
+	for _, t := range fired {
+		select {
+		case t.c <- time.Unix(0, now):
+		default:
+		}
+	}
+}
+
+func (f *FakeClock) NewTimer(d time.Duration) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTimer{c: make(chan time.Time, 1), deadline: f.now + int64(d)}
+	if int64(d) <= 0 {
+		t.c <- time.Unix(0, f.now)
+	} else {
+		f.timers = append(f.timers, t)
+	}
+	return t
+}
+
+type fakeTimer struct {
+	c        chan time.Time
+	deadline int64
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	return true
+}
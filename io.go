@@ -0,0 +1,71 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// NewByteBucket 返回一个令牌代表"字节数"的令牌桶，用于配合 Reader/Writer 对 I/O 吞吐限速。
+func NewByteBucket(fillInterval time.Duration, capacityBytes int64) Limiter {
+	return NewTokenBucket(fillInterval, capacityBytes)
+}
+
+// waitN 从 l 中扣减 n 个令牌，不足时阻塞等待，直至拿满 n 个或者 ctx 被取消。
+func waitN(ctx context.Context, l Limiter, n int64) error {
+	for n > 0 {
+		if got := l.TakeAvailableN(time.Now().UnixNano(), n); got > 0 {
+			n -= got
+			continue
+		}
+		if err := l.Wait(ctx); err != nil {
+			return err
+		}
+		n--
+	}
+	return nil
+}
+
+type rateLimitedReader struct {
+	r       io.Reader
+	limiter Limiter
+}
+
+// Reader 返回一个被 l 限速的 io.Reader：每次 Read 按实际读到的字节数从 l 中扣减令牌，
+// 不足时阻塞直到令牌可用。
+func Reader(r io.Reader, l Limiter) io.Reader {
+	return &rateLimitedReader{r: r, limiter: l}
+}
+
+func (r *rateLimitedReader) Read(buf []byte) (int, error) {
+	n, err := r.r.Read(buf)
+	if n <= 0 {
+		return n, err
+	}
+	if waitErr := waitN(context.Background(), r.limiter, int64(n)); waitErr != nil {
+		return n, waitErr
+	}
+	return n, err
+}
+
+type rateLimitedWriter struct {
+	w       io.Writer
+	limiter Limiter
+}
+
+// Writer 返回一个被 l 限速的 io.Writer：每次 Write 按写入的字节数从 l 中扣减令牌，
+// 不足时阻塞直到令牌可用。
+func Writer(w io.Writer, l Limiter) io.Writer {
+	return &rateLimitedWriter{w: w, limiter: l}
+}
+
+func (w *rateLimitedWriter) Write(buf []byte) (int, error) {
+	n, err := w.w.Write(buf)
+	if n <= 0 {
+		return n, err
+	}
+	if waitErr := waitN(context.Background(), w.limiter, int64(n)); waitErr != nil {
+		return n, waitErr
+	}
+	return n, err
+}
@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const (
+	keyedShardCount      = 32
+	keyedShardMaxEntries = 10000
+	keyedSweepInterval   = time.Minute
+)
+
+// KeyedLimiter 是一个按 key（例如 API key、IP）维度隔离的限速器注册表：每个 key
+// 第一次出现时通过 factory 创建一个独立的 Limiter，空闲超过 idleTTL 的 key 会被后台
+// sweeper 清理，避免被攻击者用海量 key 撑爆内存；每个分片另外有 keyedShardMaxEntries
+// 的 LRU 上限兜底。
+type KeyedLimiter struct {
+	factory func(key string) Limiter
+	idleTTL time.Duration
+	shards  [keyedShardCount]*keyedShard
+	done    chan struct{}
+}
+
+type keyedShard struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // Value 是 *keyedEntry，Front 为最近访问，Back 为最久未访问
+}
+
+type keyedEntry struct {
+	key        string
+	limiter    Limiter
+	lastAccess int64
+}
+
+// NewKeyedLimiter 创建一个按 key 隔离的限速器注册表，并启动后台空闲清理 goroutine。
+func NewKeyedLimiter(factory func(key string) Limiter, idleTTL time.Duration) *KeyedLimiter {
+	k := &KeyedLimiter{
+		factory: factory,
+		idleTTL: idleTTL,
+		done:    make(chan struct{}),
+	}
+	for i := range k.shards {
+		k.shards[i] = &keyedShard{
+			entries: make(map[string]*list.Element),
+			lru:     list.New(),
+		}
+	}
+	go k.sweep()
+	return k
+}
+
+func (k *KeyedLimiter) shardFor(key string) *keyedShard {
+	h := fnv.New32()
+	_, _ = h.Write([]byte(key))
+	return k.shards[h.Sum32()%keyedShardCount]
+}
+
+func (k *KeyedLimiter) limiterFor(key string) Limiter {
+	shard := k.shardFor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if elem, ok := shard.entries[key]; ok {
+		shard.lru.MoveToFront(elem)
+		entry := elem.Value.(*keyedEntry)
+		entry.lastAccess = time.Now().UnixNano()
+		return entry.limiter
+	}
+
+	entry := &keyedEntry{key: key, limiter: k.factory(key), lastAccess: time.Now().UnixNano()}
+	shard.entries[key] = shard.lru.PushFront(entry)
+
+	if shard.lru.Len() > keyedShardMaxEntries {
+		oldest := shard.lru.Back()
+		shard.lru.Remove(oldest)
+		delete(shard.entries, oldest.Value.(*keyedEntry).key)
+	}
+	return entry.limiter
+}
+
+// Allow 尝试从 key 对应的限速器取走一个令牌，返回是否取到。
+func (k *KeyedLimiter) Allow(key string) bool {
+	return k.limiterFor(key).TakeAvailable()
+}
+
+// Wait 阻塞直到 key 对应的限速器放行，或者 ctx 被取消/超时。
+func (k *KeyedLimiter) Wait(ctx context.Context, key string) error {
+	return k.limiterFor(key).Wait(ctx)
+}
+
+// Stop 终止后台的空闲清理 goroutine。
+func (k *KeyedLimiter) Stop() {
+	close(k.done)
+}
+
+func (k *KeyedLimiter) sweep() {
+	ticker := time.NewTicker(keyedSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.done:
+			return
+		case <-ticker.C:
+			k.evictIdle()
+		}
+	}
+}
+
+func (k *KeyedLimiter) evictIdle() {
+	deadline := time.Now().UnixNano() - int64(k.idleTTL)
+	for _, shard := range k.shards {
+		shard.mu.Lock()
+		for {
+			oldest := shard.lru.Back()
+			if oldest == nil || oldest.Value.(*keyedEntry).lastAccess > deadline {
+				break
+			}
+			shard.lru.Remove(oldest)
+			delete(shard.entries, oldest.Value.(*keyedEntry).key)
+		}
+		shard.mu.Unlock()
+	}
+}
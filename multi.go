@@ -0,0 +1,202 @@
+package ratelimit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// nReservable 是组合限速器需要的内部协议：tryReserveN 尝试拿 n 个令牌，返回实际
+// 拿到的数量，以及一个可以把其中 give 个令牌还回去的 cancel 函数。NewMultiLimiter
+// 用它在某一级限速器拒绝时，把之前各级已经扣掉的令牌回滚回去。
+type nReservable interface {
+	tryReserveN(now int64, n int64) (granted int64, cancel func(give int64))
+}
+
+func (t *leakyBucket) tryReserveN(now int64, n int64) (int64, func(give int64)) {
+	granted := t.TakeAvailableN(now, n)
+	if granted == 0 {
+		return 0, nil
+	}
+	return granted, func(give int64) {
+		if give <= 0 {
+			return
+		}
+		for {
+			previousStatePointer := atomic.LoadPointer(&t.lastTokenTimestamp)
+			lastTokenTimestamp := *(*int64)(previousStatePointer)
+			newLast := lastTokenTimestamp - give*t.loadConfig().perRequest
+			if atomic.CompareAndSwapPointer(&t.lastTokenTimestamp, previousStatePointer, unsafe.Pointer(&newLast)) {
+				return
+			}
+		}
+	}
+}
+
+func (t *tokenBucket) tryReserveN(now int64, n int64) (int64, func(give int64)) {
+	granted := t.TakeAvailableN(now, n)
+	if granted == 0 {
+		return 0, nil
+	}
+	return granted, func(give int64) {
+		if give <= 0 {
+			return
+		}
+		for {
+			previousStatePointer := atomic.LoadPointer(&t.tokenBucketStat)
+			stat := *(*tokenBucketStat)(previousStatePointer)
+			stat.availableTokens += give
+			if stat.availableTokens > stat.capacity {
+				stat.availableTokens = stat.capacity
+			}
+			if atomic.CompareAndSwapPointer(&t.tokenBucketStat, previousStatePointer, unsafe.Pointer(&stat)) {
+				return
+			}
+		}
+	}
+}
+
+// multiLimiter 把若干 Limiter 串联起来，只有当每一级都授予令牌时才算放行。
+type multiLimiter struct {
+	limiters []Limiter
+}
+
+// NewMultiLimiter 返回一个组合限速器：一次 Take 只有在 limiters 里每一个都还有
+// 可用令牌时才会成功；如果某一级拒绝，之前各级已经扣掉的令牌会被还回去，不会
+// 被永久消耗。典型用法是把一个按租户的限速器和一个全局限速器叠加在一起。
+func NewMultiLimiter(limiters ...Limiter) Limiter {
+	return &multiLimiter{limiters: limiters}
+}
+
+func (m *multiLimiter) TakeAvailableWithNow(now int64) bool {
+	return m.TakeAvailableN(now, 1) == 1
+}
+
+func (m *multiLimiter) TakeAvailable() bool {
+	return m.TakeAvailableWithNow(time.Now().UnixNano())
+}
+
+type multiLimiterReservation struct {
+	granted int64
+	cancel  func(give int64)
+}
+
+// reserveN 是 TakeAvailableN 和 tryReserveN 共用的核心逻辑：依次向每个子限速器
+// 请求令牌（后一级请求的是前面各级授予的最小值），一旦某一级一个令牌都不给就把
+// 已经从前面各级拿到的令牌全部回滚；否则把每一级多拿的令牌还回去，钳制到最终达成
+// 一致的数量，返回该数量以及每一级对应的 reservation
+// （reservation 的 cancel 用于后续把令牌还回去）。
+func (m *multiLimiter) reserveN(now int64, n int64) (int64, []multiLimiterReservation) {
+	if n <= 0 {
+		return 0, nil
+	}
+	reserved := make([]multiLimiterReservation, 0, len(m.limiters))
+	rollback := func() {
+		for _, r := range reserved {
+			if r.cancel != nil {
+				r.cancel(r.granted)
+			}
+		}
+	}
+
+	granted := n
+	for _, l := range m.limiters {
+		var got int64
+		var cancel func(give int64)
+		if r, ok := l.(nReservable); ok {
+			got, cancel = r.tryReserveN(now, granted)
+		} else {
+			got = l.TakeAvailableN(now, granted)
+		}
+		if got == 0 {
+			rollback()
+			return 0, nil
+		}
+		reserved = append(reserved, multiLimiterReservation{granted: got, cancel: cancel})
+		if got < granted {
+			granted = got
+		}
+	}
+
+	for i := range reserved {
+		if reserved[i].granted > granted && reserved[i].cancel != nil {
+			reserved[i].cancel(reserved[i].granted - granted)
+		}
+		reserved[i].granted = granted
+	}
+	return granted, reserved
+}
+
+// TakeAvailableN 依次向每个子限速器请求 n 个令牌；一旦某一级的授予数量不足，
+// 前面各级多拿的令牌会被回滚（该级一个都不给时全部回滚），最终只返回所有层级都认可的数量。
+func (m *multiLimiter) TakeAvailableN(now int64, n int64) int64 {
+	granted, _ := m.reserveN(now, n)
+	return granted
+}
+
+// tryReserveN 实现 nReservable：让 multiLimiter 自己也能被嵌套进外层的 NewMultiLimiter
+// 而不丢失回滚能力——否则外层某一级拒绝时，已经从这个 multiLimiter 的各个子限速器
+// 扣掉的令牌就会被永久消耗，这正是 NewMultiLimiter 本该避免的问题。
+func (m *multiLimiter) tryReserveN(now int64, n int64) (int64, func(give int64)) {
+	granted, reserved := m.reserveN(now, n)
+	if granted == 0 {
+		return 0, nil
+	}
+	return granted, func(give int64) {
+		if give <= 0 {
+			return
+		}
+		for _, r := range reserved {
+			if r.cancel != nil {
+				r.cancel(give)
+			}
+		}
+	}
+}
+
+func (m *multiLimiter) GetCapacity() int64 {
+	min := int64(-1)
+	for _, l := range m.limiters {
+		if c := l.GetCapacity(); min == -1 || c < min {
+			min = c
+		}
+	}
+	return min
+}
+
+func (m *multiLimiter) GetLegacyCapacity() int64 {
+	return -1
+}
+
+func (m *multiLimiter) Take() time.Duration {
+	var longest time.Duration
+	for _, l := range m.limiters {
+		if d := l.Take(); d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+func (m *multiLimiter) Wait(ctx context.Context) error {
+	for {
+		if m.TakeAvailable() {
+			return nil
+		}
+		if err := waitFor(ctx, m.Take()); err != nil {
+			return err
+		}
+	}
+}
+
+// SetRate 是空操作。组合限速器的各级子限速器通常配置的是不同的速率（例如
+// per-tenant 100 rps 叠加 global 10k rps），把同一个 fillInterval/capacity 广播给
+// 所有子限速器会悄悄抹掉这种分层配置。调用方应当保留构造 multiLimiter 时传入的
+// 各个 Limiter，需要重新配置时直接对相应的那一个调用 SetRate/SetCapacity。
+func (m *multiLimiter) SetRate(fillInterval time.Duration, capacity int64) {
+}
+
+// SetCapacity 是空操作，原因同 SetRate。
+func (m *multiLimiter) SetCapacity(capacity int64) {
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// TestMultiLimiterRollsBackOnPartialDenial 验证当某一级子限速器拒绝时，
+// 之前各级已经扣掉的令牌会被还回去，而不是被永久消耗。
+func TestMultiLimiterRollsBackOnPartialDenial(t *testing.T) {
+	clk := NewFakeClock(0)
+	perTenant := NewTokenBucketWithClock(time.Second, 10, clk)
+	global := NewTokenBucketWithClock(time.Second, 3, clk)
+	m := NewMultiLimiter(perTenant, global)
+
+	if got := m.TakeAvailableN(clk.Now(), 5); got != 3 {
+		t.Fatalf("expected the global limiter to cap the grant at 3, got %d", got)
+	}
+
+	// perTenant should only have given up 3, not 5: 10-3=7 left.
+	if got := perTenant.TakeAvailableN(clk.Now(), 10); got != 7 {
+		t.Fatalf("expected perTenant to have rolled back the extra 2 tokens, leaving 7, got %d", got)
+	}
+}
+
+// TestMultiLimiterNestedRollback 验证把一个 multiLimiter 作为另一个 multiLimiter
+// 的子限速器嵌套使用时，外层拒绝仍然能回滚内层已经扣掉的令牌（multiLimiter 自己
+// 也实现了 nReservable）。
+func TestMultiLimiterNestedRollback(t *testing.T) {
+	clk := NewFakeClock(0)
+	perTenant := NewTokenBucketWithClock(time.Second, 10, clk)
+	perIP := NewTokenBucketWithClock(time.Second, 10, clk)
+	inner := NewMultiLimiter(perTenant, perIP)
+
+	global := NewTokenBucketWithClock(time.Second, 3, clk)
+	outer := NewMultiLimiter(inner, global)
+
+	if got := outer.TakeAvailableN(clk.Now(), 5); got != 3 {
+		t.Fatalf("expected the global limiter to cap the grant at 3, got %d", got)
+	}
+
+	if got := perTenant.TakeAvailableN(clk.Now(), 10); got != 7 {
+		t.Fatalf("expected inner's perTenant to have rolled back to 7 remaining, got %d", got)
+	}
+	if got := perIP.TakeAvailableN(clk.Now(), 10); got != 7 {
+		t.Fatalf("expected inner's perIP to have rolled back to 7 remaining, got %d", got)
+	}
+}
+
+// TestMultiLimiterSetRateDoesNotBroadcast 验证 SetRate/SetCapacity 不会把同一个
+// fillInterval/capacity 广播给每个子限速器——组合限速器的各级通常配置不同的速率
+// （例如 per-tenant 100 rps 叠加 global 10k rps），广播会悄悄抹掉这种分层配置，
+// 调用方必须直接对想要调整的那个子限速器调用 SetRate/SetCapacity。
+func TestMultiLimiterSetRateDoesNotBroadcast(t *testing.T) {
+	clk := NewFakeClock(0)
+	perTenant := NewTokenBucketWithClock(time.Second, 100, clk)
+	global := NewTokenBucketWithClock(time.Second, 10000, clk)
+	m := NewMultiLimiter(perTenant, global)
+
+	m.SetRate(time.Second, 5)
+	m.SetCapacity(5)
+
+	if got := perTenant.GetCapacity(); got != 100 {
+		t.Fatalf("expected multiLimiter.SetRate to leave perTenant's capacity at 100, got %d", got)
+	}
+	if got := global.GetCapacity(); got != 10000 {
+		t.Fatalf("expected multiLimiter.SetRate to leave global's capacity at 10000, got %d", got)
+	}
+}
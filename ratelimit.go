@@ -1,6 +1,7 @@
 package ratelimit
 
 import (
+	"context"
 	"encoding/json"
 	"sync/atomic"
 	"time"
@@ -12,16 +13,47 @@ type Limiter interface {
 	TakeAvailable() bool
 	GetCapacity() int64
 	GetLegacyCapacity() int64
+	// Take 返回在当前时刻拿到下一个令牌前还需要等待的时长，0 表示立即可用。
+	Take() time.Duration
+	// Wait 阻塞直到拿到一个令牌或者 ctx 被取消/超时，取消时返回 ctx.Err()。
+	Wait(ctx context.Context) error
+	// SetRate 在不丢弃已积累状态的前提下，动态调整填充间隔与容量。
+	SetRate(fillInterval time.Duration, capacity int64)
+	// SetCapacity 动态调整容量，填充间隔保持不变。
+	SetCapacity(capacity int64)
+	// TakeAvailableN 尝试一次性拿 n 个令牌，返回实际拿到的数量（0 表示一个都没拿到）。
+	TakeAvailableN(now int64, n int64) int64
 }
 
 /**
   漏桶算法限制传输速率。
 */
 type leakyBucket struct {
-	capacity           int64
-	fillInterval       int64
+	config             unsafe.Pointer // *leakyBucketConfig，fillInterval/perRequest/capacity 的原子快照
 	lastTokenTimestamp unsafe.Pointer
-	perRequest         int64
+	clock              Clock
+}
+
+// leakyBucketConfig 把 fillInterval/perRequest/capacity 打包成一份快照，通过单次
+// CAS/Store 整体替换，避免 SetRate 拆成三个独立的 atomic.StoreInt64 导致读者看到
+// 新旧字段的撕裂组合。
+type leakyBucketConfig struct {
+	fillInterval int64
+	perRequest   int64
+	capacity     int64
+}
+
+func (t *leakyBucket) loadConfig() *leakyBucketConfig {
+	return (*leakyBucketConfig)(atomic.LoadPointer(&t.config))
+}
+
+// perRequestFor 计算 perRequest：capacity<=0 时退化为 0（TakeAvailableN 会把它视为
+// “总是可用”），避免对非正 capacity 做除法。
+func perRequestFor(fillIntervalInt int64, capacity int64) int64 {
+	if capacity <= 0 {
+		return 0
+	}
+	return fillIntervalInt / capacity
 }
 
 func (t *leakyBucket) TakeAvailableWithNow(now int64) bool {
@@ -31,7 +63,7 @@ func (t *leakyBucket) TakeAvailableWithNow(now int64) bool {
 		previousStatePointer := atomic.LoadPointer(&t.lastTokenTimestamp)
 		lastTokenTimestamp := (*int64)(previousStatePointer)
 
-		newLast = *lastTokenTimestamp + t.perRequest
+		newLast = *lastTokenTimestamp + t.loadConfig().perRequest
 
 		if now < newLast {
 			break
@@ -43,30 +75,115 @@ func (t *leakyBucket) TakeAvailableWithNow(now int64) bool {
 }
 
 func (t *leakyBucket) TakeAvailable() bool {
-	return t.TakeAvailableWithNow(time.Now().UnixNano())
+	return t.TakeAvailableWithNow(t.clock.Now())
 }
 
 func (t *leakyBucket) GetCapacity() int64 {
-	return t.capacity
+	return t.loadConfig().capacity
 }
 func (t *leakyBucket) GetLegacyCapacity() int64 {
 	return -1
 }
 
+func (t *leakyBucket) Take() time.Duration {
+	now := t.clock.Now()
+	previousStatePointer := atomic.LoadPointer(&t.lastTokenTimestamp)
+	lastTokenTimestamp := (*int64)(previousStatePointer)
+	newLast := *lastTokenTimestamp + t.loadConfig().perRequest
+	if now >= newLast {
+		return 0
+	}
+	return time.Duration(newLast - now)
+}
+
+// SetRate 动态调整漏桶的填充间隔与容量，重新计算 perRequest，一次性 Store 一份新的
+// leakyBucketConfig，不会丢弃 lastTokenTimestamp 已积累的状态。capacity<=0 时
+// perRequest 退化为 0（视为总是可用），而不是对 capacity 做除法。
+func (t *leakyBucket) SetRate(fillInterval time.Duration, capacity int64) {
+	if capacity < 0 {
+		capacity = 0
+	}
+	fillIntervalInt := int64(fillInterval)
+	cfg := &leakyBucketConfig{
+		fillInterval: fillIntervalInt,
+		perRequest:   perRequestFor(fillIntervalInt, capacity),
+		capacity:     capacity,
+	}
+	atomic.StorePointer(&t.config, unsafe.Pointer(cfg))
+}
+
+// SetCapacity 动态调整漏桶容量，填充间隔保持不变。
+func (t *leakyBucket) SetCapacity(capacity int64) {
+	t.SetRate(time.Duration(t.loadConfig().fillInterval), capacity)
+}
+
+// TakeAvailableN 尝试一次性拿 n 个令牌，返回实际拿到的数量（0 表示一个都没拿到）。
+func (t *leakyBucket) TakeAvailableN(now int64, n int64) int64 {
+	if n <= 0 {
+		return 0
+	}
+	for {
+		previousStatePointer := atomic.LoadPointer(&t.lastTokenTimestamp)
+		lastTokenTimestamp := *(*int64)(previousStatePointer)
+		perRequest := t.loadConfig().perRequest
+
+		if perRequest == 0 {
+			// fillInterval/capacity 截断为 0（例如 capacity 超过 fillInterval 的纳秒数，
+			// 或 capacity<=0）时，退化为“令牌总是可用”，而不是对 perRequest 做除法，
+			// 避免除零 panic。
+			return n
+		}
+
+		maxGrantable := (now - lastTokenTimestamp) / perRequest
+		if maxGrantable <= 0 {
+			return 0
+		}
+		granted := n
+		if granted > maxGrantable {
+			granted = maxGrantable
+		}
+		newLast := lastTokenTimestamp + granted*perRequest
+		if atomic.CompareAndSwapPointer(&t.lastTokenTimestamp, previousStatePointer, unsafe.Pointer(&newLast)) {
+			return granted
+		}
+	}
+}
+
+func (t *leakyBucket) Wait(ctx context.Context) error {
+	for {
+		if t.TakeAvailable() {
+			return nil
+		}
+		if err := waitForClock(ctx, t.clock, t.Take()); err != nil {
+			return err
+		}
+	}
+}
+
 func (t *leakyBucket) MarshalJSON() ([]byte, error) {
 	object := map[string]interface{}{}
-	object["capacity"] = t.capacity
+	object["capacity"] = t.loadConfig().capacity
 	return json.Marshal(object)
 }
 
 func NewLeakyBucket(fillInterval time.Duration, capacity int64) Limiter {
+	return NewLeakyBucketWithClock(fillInterval, capacity, NewRealClock())
+}
+
+// NewLeakyBucketWithClock 和 NewLeakyBucket 一样，但允许注入自定义的 Clock，
+// 便于测试用 FakeClock 手动推进时间，而不必依赖真实的 sleep。
+func NewLeakyBucketWithClock(fillInterval time.Duration, capacity int64, clock Clock) Limiter {
 	fillIntervalInt := int64(fillInterval)
-	l := &leakyBucket{
+	cfg := leakyBucketConfig{
 		fillInterval: fillIntervalInt,
-		perRequest:   fillIntervalInt / capacity,
+		perRequest:   perRequestFor(fillIntervalInt, capacity),
 		capacity:     capacity,
 	}
-	lastTokenTimestamp := time.Now().UnixNano()
+	l := &leakyBucket{
+		config: unsafe.Pointer(&cfg),
+		clock:  clock,
+	}
+	lastTokenTimestamp := clock.Now()
 	l.lastTokenTimestamp = unsafe.Pointer(&lastTokenTimestamp)
 	return l
 }
@@ -75,63 +192,155 @@ func NewLeakyBucket(fillInterval time.Duration, capacity int64) Limiter {
 令牌桶算法能够限制突发传输。
 */
 type tokenBucket struct {
-	capacity        int64
-	fillInterval    int64
+	quantum         int64
 	tokenBucketStat unsafe.Pointer
-	perRequest      int64
+	clock           Clock
 }
 
+// tokenBucketStat 把 fillInterval/capacity/startTime 这些由 SetRate 调整的配置，和
+// availableTokens/latestTick 这些随每次 Take 变化的状态打包成一份快照，通过单次 CAS
+// 整体替换，避免配置和可用令牌数分开存储时被并发的 Take/SetRate 读到不一致的组合。
 type tokenBucketStat struct {
-	nextTokenTimestamp int64
-	keepCapacity       int64 //窗口时间
+	fillInterval    int64
+	capacity        int64
+	startTime       int64
+	availableTokens int64
+	latestTick      int64
+}
+
+// tick 返回 now 相对于 stat.startTime 已经过去的完整 fillInterval 个数。
+func tick(stat tokenBucketStat, now int64) int64 {
+	return (now - stat.startTime) / stat.fillInterval
+}
+
+// advance 把 stat 按经过的 tick 数补发 quantum 个令牌，钳制到 stat.capacity。
+func (t *tokenBucket) advance(stat tokenBucketStat, tick int64) tokenBucketStat {
+	if tick <= stat.latestTick {
+		return stat
+	}
+	stat.availableTokens += (tick - stat.latestTick) * atomic.LoadInt64(&t.quantum)
+	if stat.availableTokens > stat.capacity {
+		stat.availableTokens = stat.capacity
+	}
+	stat.latestTick = tick
+	return stat
 }
 
 func (t *tokenBucket) TakeAvailableWithNow(now int64) bool {
-	taken := false
-	for !taken {
-		newStat := tokenBucketStat{}
-		lastTokenBucketStatPointer := atomic.LoadPointer(&t.tokenBucketStat)
-		lastTokenBucketStat := (*tokenBucketStat)(lastTokenBucketStatPointer)
+	return t.TakeAvailableN(now, 1) == 1
+}
+
+func (t *tokenBucket) TakeAvailable() bool {
+	return t.TakeAvailableWithNow(t.clock.Now())
+}
 
-		if now > lastTokenBucketStat.nextTokenTimestamp {
-			newStat.nextTokenTimestamp = lastTokenBucketStat.nextTokenTimestamp + t.fillInterval
-			newStat.keepCapacity = t.capacity - 1
+func (t *tokenBucket) GetCapacity() int64 {
+	statePointer := atomic.LoadPointer(&t.tokenBucketStat)
+	return (*tokenBucketStat)(statePointer).capacity
+}
 
-		} else {
+// SetRate 动态调整令牌桶的填充间隔与容量：把当前已积累的可用令牌数钳制到新容量后，
+// 连同新的 fillInterval/capacity/startTime 一起打包进一个新的 tokenBucketStat，
+// 用单次 CAS 整体替换，避免配置字段和 availableTokens 分开更新时出现撕裂的中间状态。
+// capacity<0 会被当作 0 处理。
+func (t *tokenBucket) SetRate(fillInterval time.Duration, capacity int64) {
+	if capacity < 0 {
+		capacity = 0
+	}
+	fillIntervalInt := int64(fillInterval)
+	now := t.clock.Now()
+	for {
+		previousStatePointer := atomic.LoadPointer(&t.tokenBucketStat)
+		prevStat := *(*tokenBucketStat)(previousStatePointer)
+		stat := t.advance(prevStat, tick(prevStat, now))
 
-			if lastTokenBucketStat.keepCapacity <= 0 {
-				break
-			} else {
-				newStat.nextTokenTimestamp = lastTokenBucketStat.nextTokenTimestamp
-				newStat.keepCapacity = lastTokenBucketStat.keepCapacity - 1
-			}
+		if stat.availableTokens > capacity {
+			stat.availableTokens = capacity
+		}
+		newStat := tokenBucketStat{
+			fillInterval:    fillIntervalInt,
+			capacity:        capacity,
+			startTime:       now,
+			availableTokens: stat.availableTokens,
+			latestTick:      0,
+		}
+		if atomic.CompareAndSwapPointer(&t.tokenBucketStat, previousStatePointer, unsafe.Pointer(&newStat)) {
+			return
 		}
-		taken = atomic.CompareAndSwapPointer(&t.tokenBucketStat, lastTokenBucketStatPointer, unsafe.Pointer(&newStat))
+	}
+}
+
+// SetCapacity 动态调整令牌桶容量，填充间隔保持不变。
+func (t *tokenBucket) SetCapacity(capacity int64) {
+	statePointer := atomic.LoadPointer(&t.tokenBucketStat)
+	fillInterval := (*tokenBucketStat)(statePointer).fillInterval
+	t.SetRate(time.Duration(fillInterval), capacity)
+}
 
+func (t *tokenBucket) Take() time.Duration {
+	now := t.clock.Now()
+	previousStatePointer := atomic.LoadPointer(&t.tokenBucketStat)
+	prevStat := *(*tokenBucketStat)(previousStatePointer)
+	stat := t.advance(prevStat, tick(prevStat, now))
+	if stat.availableTokens > 0 {
+		return 0
 	}
-	return taken
+	nextTick := stat.latestTick + 1
+	nextTimestamp := stat.startTime + nextTick*stat.fillInterval
+	if wait := nextTimestamp - now; wait > 0 {
+		return time.Duration(wait)
+	}
+	return 0
 }
 
-func (t *tokenBucket) TakeAvailable() bool {
-	return t.TakeAvailableWithNow(time.Now().UnixNano())
+// TakeAvailableN 尝试一次性拿 n 个令牌，返回实际拿到的数量（0 表示一个都没拿到）。
+// 令牌按 (now-startTime)/fillInterval*quantum 连续累积，而不是每个 fillInterval 只发一个。
+func (t *tokenBucket) TakeAvailableN(now int64, n int64) int64 {
+	if n <= 0 {
+		return 0
+	}
+	for {
+		previousStatePointer := atomic.LoadPointer(&t.tokenBucketStat)
+		prevStat := *(*tokenBucketStat)(previousStatePointer)
+		stat := t.advance(prevStat, tick(prevStat, now))
+
+		if stat.availableTokens <= 0 {
+			return 0
+		}
+		granted := n
+		if granted > stat.availableTokens {
+			granted = stat.availableTokens
+		}
+		stat.availableTokens -= granted
+		if atomic.CompareAndSwapPointer(&t.tokenBucketStat, previousStatePointer, unsafe.Pointer(&stat)) {
+			return granted
+		}
+	}
 }
 
-func (t *tokenBucket) GetCapacity() int64 {
-	return t.capacity
+func (t *tokenBucket) Wait(ctx context.Context) error {
+	for {
+		if t.TakeAvailable() {
+			return nil
+		}
+		if err := waitForClock(ctx, t.clock, t.Take()); err != nil {
+			return err
+		}
+	}
 }
 
 func (t *tokenBucket) GetLegacyCapacity() int64 {
 	lastTokenBucketStatPointer := atomic.LoadPointer(&t.tokenBucketStat)
 	lastTokenBucketStat := (*tokenBucketStat)(lastTokenBucketStatPointer)
-	return lastTokenBucketStat.keepCapacity
+	return lastTokenBucketStat.availableTokens
 }
 
 func (t *tokenBucket) MarshalJSON() ([]byte, error) {
 	object := map[string]interface{}{}
-	object["capacity"] = t.capacity
 	lastTokenBucketStatPointer := atomic.LoadPointer(&t.tokenBucketStat)
 	lastTokenBucketStat := (*tokenBucketStat)(lastTokenBucketStatPointer)
-	object["keepCapacity"] = lastTokenBucketStat.keepCapacity
+	object["capacity"] = lastTokenBucketStat.capacity
+	object["keepCapacity"] = lastTokenBucketStat.availableTokens
 	return json.Marshal(object)
 }
 
@@ -139,15 +348,62 @@ func (t *tokenBucket) MarshalJSON() ([]byte, error) {
 令牌桶算法能够突发传输。
 */
 func NewTokenBucket(fillInterval time.Duration, capacity int64) Limiter {
-	fillIntervalInt := int64(fillInterval)
+	return NewTokenBucketWithQuantum(fillInterval, 1, capacity)
+}
+
+// NewTokenBucketWithClock 和 NewTokenBucket 一样，但允许注入自定义的 Clock，
+// 便于测试用 FakeClock 手动推进时间，而不必依赖真实的 sleep。
+func NewTokenBucketWithClock(fillInterval time.Duration, capacity int64, clock Clock) Limiter {
+	return NewTokenBucketWithQuantumAndClock(fillInterval, 1, capacity, clock)
+}
+
+// NewTokenBucketWithQuantum 与 NewTokenBucket 类似，但允许配置每个 fillInterval 发放的
+// 令牌数（quantum），用于表达 >1e9 tok/s 这类无法用单个 fillInterval 精确表示的高速率限制。
+func NewTokenBucketWithQuantum(fillInterval time.Duration, quantum int64, capacity int64) Limiter {
+	return NewTokenBucketWithQuantumAndClock(fillInterval, quantum, capacity, NewRealClock())
+}
+
+// NewTokenBucketWithQuantumAndClock 是所有令牌桶构造函数最终委托的实现，
+// 同时允许配置 quantum 和注入的 Clock。
+func NewTokenBucketWithQuantumAndClock(fillInterval time.Duration, quantum int64, capacity int64, clock Clock) Limiter {
 	l := &tokenBucket{
-		fillInterval: fillIntervalInt,
-		capacity:     capacity,
+		quantum: quantum,
+		clock:   clock,
 	}
-	tokenBucketStat := tokenBucketStat{
-		nextTokenTimestamp: time.Now().UnixNano(),
-		keepCapacity:       capacity,
+	stat := tokenBucketStat{
+		fillInterval:    int64(fillInterval),
+		capacity:        capacity,
+		startTime:       clock.Now(),
+		availableTokens: capacity,
+		latestTick:      0,
 	}
-	l.tokenBucketStat = unsafe.Pointer(&tokenBucketStat)
+	l.tokenBucketStat = unsafe.Pointer(&stat)
 	return l
 }
+
+// waitForClock 阻塞 d 时长（使用 clock 提供的定时器），如果 ctx 提前被取消/超时
+// 则立即返回 ctx.Err()，并保证定时器被释放，不会泄漏。
+func waitForClock(ctx context.Context, clock Clock, d time.Duration) error {
+	if d <= 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			return nil
+		}
+	}
+	timer := clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// waitFor 阻塞 d 时长，如果 ctx 提前被取消/超时则立即返回 ctx.Err()，
+// 并保证定时器被释放，不会泄漏。multiLimiter 没有单一的注入时钟，所以继续使用真实时间。
+func waitFor(ctx context.Context, d time.Duration) error {
+	return waitForClock(ctx, NewRealClock(), d)
+}
@@ -0,0 +1,204 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestWaitBlocksThenUnblocksOnAdvance 验证 Wait 在没有可用令牌时会阻塞，
+// 时钟推进到下一个令牌到账后才返回，全程不依赖真实 sleep。
+func TestWaitBlocksThenUnblocksOnAdvance(t *testing.T) {
+	clk := NewFakeClock(0)
+	l := NewTokenBucketWithClock(time.Second, 1, clk)
+	if !l.TakeAvailable() {
+		t.Fatalf("expected initial token to be available")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- l.Wait(context.Background())
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Wait returned early before a token refilled: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clk.Advance(time.Second)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Wait returned error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Wait did not unblock after the clock advanced")
+	}
+}
+
+// TestWaitCancellation 验证 Wait 在 ctx 被取消时立即返回 ctx.Err()，而不是一直阻塞。
+func TestWaitCancellation(t *testing.T) {
+	clk := NewFakeClock(0)
+	l := NewTokenBucketWithClock(time.Second, 1, clk)
+	if !l.TakeAvailable() {
+		t.Fatalf("expected initial token to be available")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- l.Wait(ctx)
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Wait did not unblock after ctx was canceled")
+	}
+}
+
+// TestTokenBucketSetRateClampsAvailableTokens 验证 SetRate 缩小容量时会把
+// 已积累的可用令牌钳制到新容量，放大容量时不会凭空多发令牌。
+func TestTokenBucketSetRateClampsAvailableTokens(t *testing.T) {
+	clk := NewFakeClock(0)
+	l := NewTokenBucketWithClock(time.Second, 10, clk)
+
+	l.SetRate(time.Second, 3)
+	if got := l.TakeAvailableN(clk.Now(), 10); got != 3 {
+		t.Fatalf("expected SetRate to clamp available tokens to 3, got %d", got)
+	}
+
+	l.SetRate(time.Second, 5)
+	if got := l.TakeAvailableN(clk.Now(), 10); got != 0 {
+		t.Fatalf("expected no tokens to be available right after growing the capacity to 5, got %d", got)
+	}
+	clk.Advance(time.Second)
+	if got := l.TakeAvailableN(clk.Now(), 10); got != 1 {
+		t.Fatalf("expected exactly one fillInterval worth of tokens (1), got %d", got)
+	}
+}
+
+// TestTokenBucketFractionalRefill 验证按经过时间的补发：不足一个 fillInterval
+// 的时间不会补发令牌，但也不会丢失，累计满一个 fillInterval 后一次补发 quantum 个令牌。
+func TestTokenBucketFractionalRefill(t *testing.T) {
+	clk := NewFakeClock(0)
+	l := NewTokenBucketWithQuantumAndClock(time.Second, 4, 10, clk)
+
+	if got := l.TakeAvailableN(clk.Now(), 10); got != 10 {
+		t.Fatalf("expected initial capacity of 10, got %d", got)
+	}
+
+	clk.Advance(250 * time.Millisecond)
+	if got := l.TakeAvailableN(clk.Now(), 1); got != 0 {
+		t.Fatalf("expected no tokens before a full fillInterval has elapsed, got %d", got)
+	}
+
+	clk.Advance(750 * time.Millisecond)
+	if got := l.TakeAvailableN(clk.Now(), 10); got != 4 {
+		t.Fatalf("expected one fillInterval worth of quantum (4), got %d", got)
+	}
+}
+
+// TestTokenBucketSetRateIsAtomicUnderConcurrency 验证 SetRate 把 fillInterval/
+// capacity/startTime/availableTokens 作为一份快照整体 CAS 进去：并发的 TakeAvailableN
+// 不应该看到新 fillInterval/startTime 和旧的、钳制之前的 availableTokens 撞在一起，
+// 从而拿到超过任何一次 SetRate 设置过的容量的令牌数。
+func TestTokenBucketSetRateIsAtomicUnderConcurrency(t *testing.T) {
+	l := NewTokenBucket(time.Second, 1000)
+	var stop int32
+	var maxGranted int64
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for atomic.LoadInt32(&stop) == 0 {
+			l.SetRate(time.Second, 1000)
+			l.SetRate(time.Second, 5)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		now := time.Now().UnixNano()
+		for i := 0; i < 50000; i++ {
+			if got := l.TakeAvailableN(now, 1000); got > 0 {
+				for {
+					old := atomic.LoadInt64(&maxGranted)
+					if got <= old || atomic.CompareAndSwapInt64(&maxGranted, old, got) {
+						break
+					}
+				}
+			}
+		}
+		atomic.StoreInt32(&stop, 1)
+	}()
+	wg.Wait()
+
+	if maxGranted > 1000 {
+		t.Fatalf("observed a grant of %d, which exceeds every capacity SetRate ever configured", maxGranted)
+	}
+}
+
+// TestLeakyBucketTakeAvailableN 覆盖 capacity 超过 fillInterval 纳秒数（perRequest
+// 截断为 0）的大吞吐配置，这类场景下 TakeAvailableN 必须把令牌视为总是可用，
+// 而不是对截断为 0 的 perRequest 做除法。
+func TestLeakyBucketTakeAvailableN(t *testing.T) {
+	clk := NewFakeClock(0)
+	l := NewLeakyBucketWithClock(time.Millisecond, 10_000_000, clk)
+
+	if got := l.TakeAvailableN(clk.Now(), 5); got != 5 {
+		t.Fatalf("expected perRequest==0 to behave as always-available, got %d", got)
+	}
+}
+
+// TestLeakyBucketSetRateZeroCapacityDoesNotPanic 覆盖配置重载把 capacity 调成 0 的场景
+// （例如用来暂停限速器），SetRate 必须照样生效而不是对 capacity 做除法 panic。
+func TestLeakyBucketSetRateZeroCapacityDoesNotPanic(t *testing.T) {
+	clk := NewFakeClock(0)
+	l := NewLeakyBucketWithClock(time.Second, 10, clk)
+
+	l.SetRate(time.Second, 0)
+	if got := l.TakeAvailableN(clk.Now(), 1); got != 1 {
+		t.Fatalf("expected SetRate(_, 0) to degrade to always-available instead of panicking, got %d", got)
+	}
+	if got := l.GetCapacity(); got != 0 {
+		t.Fatalf("expected GetCapacity to report 0 after SetRate(_, 0), got %d", got)
+	}
+}
+
+// TestLeakyBucketSetRateIsAtomicUnderConcurrency 验证 SetRate 把 fillInterval/
+// perRequest/capacity 作为一份快照整体替换：并发调用不应该产生一次 Store 看到新
+// fillInterval 却搭配旧 perRequest/capacity 的撕裂组合（GetCapacity 必须始终是
+// 某一次完整 SetRate 调用设置过的值之一）。
+func TestLeakyBucketSetRateIsAtomicUnderConcurrency(t *testing.T) {
+	l := NewLeakyBucket(time.Second, 1000)
+	var stop int32
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for atomic.LoadInt32(&stop) == 0 {
+			l.SetRate(time.Second, 1000)
+			l.SetRate(2*time.Second, 5)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50000; i++ {
+			if got := l.GetCapacity(); got != 1000 && got != 5 {
+				t.Errorf("observed torn capacity %d, expected only 1000 or 5", got)
+			}
+		}
+		atomic.StoreInt32(&stop, 1)
+	}()
+	wg.Wait()
+}